@@ -0,0 +1,105 @@
+/*
+Copyright IBM Corp. 2016-2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configtx
+
+import (
+	"fmt"
+	"testing"
+
+	cb "github.com/hyperledger/fabric/protos/common"
+)
+
+// fakeConfigManager is a hand-rolled ConfigManager double: it lets tests drive ApplyBatch's
+// validate/commit phases without a real proto-backed configManager, and records whether
+// commitEvaluation was ever called so partial-failure atomicity can be asserted directly.
+type fakeConfigManager struct {
+	evaluateErr error
+	committed   bool
+	locked      bool
+}
+
+func (f *fakeConfigManager) SetModPolicyResolver(ModPolicyResolver) {}
+
+func (f *fakeConfigManager) evaluateUpdate(*cb.ConfigUpdateEnvelope, bool) (*updateEvaluation, error) {
+	if f.evaluateErr != nil {
+		return nil, f.evaluateErr
+	}
+	return &updateEvaluation{seq: 1}, nil
+}
+
+func (f *fakeConfigManager) commitEvaluation(*updateEvaluation) { f.committed = true }
+
+func (f *fakeConfigManager) lock()   { f.locked = true }
+func (f *fakeConfigManager) unlock() { f.locked = false }
+
+func TestApplyBatch(t *testing.T) {
+	t.Run("all channels succeed and are committed", func(t *testing.T) {
+		a := &fakeConfigManager{}
+		b := &fakeConfigManager{}
+		managers := map[string]ConfigManager{"chanA": a, "chanB": b}
+		updates := map[string]*cb.ConfigUpdateEnvelope{
+			"chanA": {},
+			"chanB": {},
+		}
+
+		if err := ApplyBatch(managers, updates); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !a.committed || !b.committed {
+			t.Fatalf("expected both channels to be committed, got a=%v b=%v", a.committed, b.committed)
+		}
+		if a.locked || b.locked {
+			t.Fatalf("expected every lock to be released once the batch returned")
+		}
+	})
+
+	t.Run("a single channel failing validation commits nothing", func(t *testing.T) {
+		a := &fakeConfigManager{}
+		b := &fakeConfigManager{evaluateErr: fmt.Errorf("policy not satisfied")}
+		managers := map[string]ConfigManager{"chanA": a, "chanB": b}
+		updates := map[string]*cb.ConfigUpdateEnvelope{
+			"chanA": {},
+			"chanB": {},
+		}
+
+		if err := ApplyBatch(managers, updates); err == nil {
+			t.Fatal("expected an error when one channel fails validation")
+		}
+		if a.committed || b.committed {
+			t.Fatalf("expected no channel to be committed when any channel fails, got a=%v b=%v", a.committed, b.committed)
+		}
+		if a.locked || b.locked {
+			t.Fatalf("expected every lock to be released after a failed batch")
+		}
+	})
+
+	t.Run("a channel missing from managers aborts before committing anything", func(t *testing.T) {
+		a := &fakeConfigManager{}
+		managers := map[string]ConfigManager{"chanA": a}
+		updates := map[string]*cb.ConfigUpdateEnvelope{
+			"chanA": {},
+			"chanB": {},
+		}
+
+		if err := ApplyBatch(managers, updates); err == nil {
+			t.Fatal("expected an error when a channel in updates has no corresponding manager")
+		}
+		if a.committed {
+			t.Fatalf("expected chanA to not be committed when chanB has no manager")
+		}
+	})
+}
@@ -0,0 +1,111 @@
+/*
+Copyright IBM Corp. 2016-2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configtx
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/policies"
+)
+
+// fakePolicyManager is a minimal policies.Manager double, keyed by the "/"-joined submanager
+// path, letting ModPolicyResolver implementations be tested without a real PolicyManager.
+type fakePolicyManager struct {
+	policies map[string]policies.Policy
+	managers map[string]*fakePolicyManager
+}
+
+func (f *fakePolicyManager) GetPolicy(id string) (policies.Policy, bool) {
+	p, ok := f.policies[id]
+	return p, ok
+}
+
+func (f *fakePolicyManager) Manager(path []string) (policies.Manager, bool) {
+	if len(path) == 0 {
+		return f, true
+	}
+	m, ok := f.managers[strings.Join(path, "/")]
+	if !ok {
+		return nil, false
+	}
+	return m, true
+}
+
+func TestDefaultModPolicyResolver(t *testing.T) {
+	channelManager := &fakePolicyManager{policies: map[string]policies.Policy{"Admins": stubPolicy{}}}
+	appManager := &fakePolicyManager{policies: map[string]policies.Policy{"Writers": stubPolicy{}}}
+	channelManager.managers = map[string]*fakePolicyManager{"Application": appManager}
+
+	t.Run("absolute path is looked up directly", func(t *testing.T) {
+		item := valueComparable([]string{"Channel"}, 1, PathSeparator+"Admins")
+		if _, ok := (defaultModPolicyResolver{}).PolicyForItem(channelManager, item); !ok {
+			t.Fatal("expected the absolute-path policy to resolve")
+		}
+	})
+
+	t.Run("relative policy resolves against the item's own group", func(t *testing.T) {
+		item := valueComparable([]string{"Channel", "Application"}, 1, "Writers")
+		if _, ok := (defaultModPolicyResolver{}).PolicyForItem(channelManager, item); !ok {
+			t.Fatal("expected the relative policy to resolve against the Application submanager")
+		}
+	})
+
+	t.Run("missing submanager fails resolution", func(t *testing.T) {
+		item := valueComparable([]string{"Channel", "Orderer", "MSPs"}, 1, "Writers")
+		if _, ok := (defaultModPolicyResolver{}).PolicyForItem(channelManager, item); ok {
+			t.Fatal("expected resolution to fail when the submanager does not exist")
+		}
+	})
+}
+
+func TestCompositeModPolicyResolver(t *testing.T) {
+	channelManager := &fakePolicyManager{policies: map[string]policies.Policy{"Admins": stubPolicy{}}}
+	appManager := &fakePolicyManager{policies: map[string]policies.Policy{"Writers": stubPolicy{}}}
+	channelManager.managers = map[string]*fakePolicyManager{"Application": appManager}
+
+	resolver := compositeModPolicyResolver{Fallback: "Writers"}
+
+	t.Run("alias resolves against the named ancestor group regardless of nesting", func(t *testing.T) {
+		item := valueComparable([]string{"Channel", "Application"}, 1, "Admins@Channel")
+		if _, ok := resolver.PolicyForItem(channelManager, item); !ok {
+			t.Fatal("expected the Admins@Channel alias to resolve against the channel root")
+		}
+	})
+
+	t.Run("unmatched alias group fails resolution", func(t *testing.T) {
+		item := valueComparable([]string{"Channel", "Application"}, 1, "Admins@Orderer")
+		if _, ok := resolver.PolicyForItem(channelManager, item); ok {
+			t.Fatal("expected resolution to fail when the aliased group isn't in the item's path")
+		}
+	})
+
+	t.Run("empty mod_policy falls back and walks up to the first ancestor that defines it", func(t *testing.T) {
+		item := valueComparable([]string{"Channel", "Application"}, 1, "")
+		if _, ok := resolver.PolicyForItem(channelManager, item); !ok {
+			t.Fatal("expected the Fallback policy to resolve by walking up to the Application submanager")
+		}
+	})
+
+	t.Run("inheritance walk reaches the channel root when no submanager defines the policy", func(t *testing.T) {
+		resolver := compositeModPolicyResolver{Fallback: "Admins"}
+		item := valueComparable([]string{"Channel", "Application"}, 1, "")
+		if _, ok := resolver.PolicyForItem(channelManager, item); !ok {
+			t.Fatal("expected the inheritance walk to find Admins at the channel root")
+		}
+	})
+}
@@ -18,16 +18,56 @@ package configtx
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/hyperledger/fabric/common/policies"
 	cb "github.com/hyperledger/fabric/protos/common"
 	"github.com/hyperledger/fabric/protos/utils"
 )
 
-// authorizeUpdate validates that all modified config has the corresponding modification policies satisfied by the signature set
-// it returns a map of the modified config
-func (cm *configManager) authorizeUpdate(configUpdateEnv *cb.ConfigUpdateEnvelope) (map[string]comparable, error) {
+// groupPrefix is the key prefix mapConfig assigns to nested ConfigGroup entries, e.g. "[Groups] /Channel".
+const groupPrefix = "[Groups] "
+
+// ConfigKeyDiff describes the effect a proposed config update would have on a single config key,
+// as produced by SimulateUpdate.
+type ConfigKeyDiff struct {
+	Key             string
+	OldValue        comparable // zero value if the key did not previously exist
+	NewValue        comparable // zero value if the key is being deleted
+	New             bool
+	Deleted         bool
+	ModPolicy       string
+	PolicySatisfied bool
+}
+
+// ConfigSimulationResult is the structured diff returned by SimulateUpdate, describing what a
+// proposed config update would change and whether it is currently authorized to do so.
+type ConfigSimulationResult struct {
+	Sequence uint64
+	Diffs    []ConfigKeyDiff
+}
+
+// updateEvaluation is the outcome of running the shared authorizeUpdate/SimulateUpdate evaluation
+// core over a single ConfigUpdateEnvelope.
+type updateEvaluation struct {
+	seq         uint64
+	configMap   map[string]comparable
+	deletedKeys map[string]struct{}
+	diffs       []ConfigKeyDiff
+}
+
+// evaluateUpdate is the pure core shared by authorizeUpdate and SimulateUpdate: it never mutates
+// cm, and computes the resulting configMap, the set of deleted keys, and a per-key diff.
+//
+// When strict is true (the authorizeUpdate/Apply path), the first unsatisfied modification
+// policy or structural problem is returned as an error, exactly as before. When strict is false
+// (the SimulateUpdate path), an unsatisfied modification policy is recorded in the diff with
+// PolicySatisfied false instead of aborting, so callers can see every reason an update would be
+// rejected rather than just the first one; structural problems that make the update impossible
+// to reason about (malformed envelope, wrong chain, sequence jump) are still returned as errors.
+func (cm *configManager) evaluateUpdate(configUpdateEnv *cb.ConfigUpdateEnvelope, strict bool) (*updateEvaluation, error) {
 	if configUpdateEnv == nil {
 		return nil, fmt.Errorf("Cannot process nil ConfigUpdateEnvelope")
 	}
@@ -42,9 +82,6 @@ func (cm *configManager) authorizeUpdate(configUpdateEnv *cb.ConfigUpdateEnvelop
 	}
 
 	seq := computeSequence(config.WriteSet)
-	if err != nil {
-		return nil, err
-	}
 
 	signedData, err := configUpdateEnv.AsSignedData()
 	if err != nil {
@@ -65,9 +102,15 @@ func (cm *configManager) authorizeUpdate(configUpdateEnv *cb.ConfigUpdateEnvelop
 	if err != nil {
 		return nil, err
 	}
+
+	result := &updateEvaluation{
+		seq:       seq,
+		configMap: configMap,
+	}
+
 	for key, value := range configMap {
 		logger.Debugf("Processing key %s with value %v", key, value)
-		if key == "[Groups] /Channel" {
+		if key == groupPrefix+"/Channel" {
 			// XXX temporary hack to prevent group evaluation for modification
 			continue
 		}
@@ -85,60 +128,447 @@ func (cm *configManager) authorizeUpdate(configUpdateEnv *cb.ConfigUpdateEnvelop
 			isModified = true
 		}
 
+		if !isModified {
+			continue
+		}
+
+		logger.Debugf("Proposed config item %s on channel %s has been modified", key, cm.chainID)
+
 		// If a config item was modified, its Version must be set correctly, and it must satisfy the modification policy
-		if isModified {
-			logger.Debugf("Proposed config item %s on channel %s has been modified", key, cm.chainID)
+		if value.version() != seq {
+			return nil, fmt.Errorf("Key %s was modified, but its Version %d does not equal current configtx Sequence %d", key, value.version(), seq)
+		}
 
-			if value.version() != seq {
-				return nil, fmt.Errorf("Key %s was modified, but its Version %d does not equal current configtx Sequence %d", key, value.version(), seq)
+		diff := ConfigKeyDiff{Key: key, NewValue: value, New: !ok}
+
+		// Get the modification policy for this config item if one was previously specified
+		// or accept it if it is new, as the group policy will be evaluated for its inclusion
+		if ok {
+			diff.OldValue = oldValue
+			diff.ModPolicy = oldValue.modPolicy()
+
+			policy, ok := cm.policyForItem(oldValue)
+			if !ok {
+				return nil, fmt.Errorf("Unexpected missing policy %s for item %s", oldValue.modPolicy(), key)
 			}
 
-			// Get the modification policy for this config item if one was previously specified
-			// or accept it if it is new, as the group policy will be evaluated for its inclusion
-			if ok {
-				policy, ok := cm.policyForItem(oldValue)
-				if !ok {
-					return nil, fmt.Errorf("Unexpected missing policy %s for item %s", oldValue.modPolicy(), key)
+			// Ensure the policy is satisfied
+			if err = policy.Evaluate(signedData); err != nil {
+				if strict {
+					return nil, fmt.Errorf("Policy for %s not satisfied: %s", key, err)
 				}
+				diff.PolicySatisfied = false
+			} else {
+				diff.PolicySatisfied = true
+			}
+		} else {
+			diff.PolicySatisfied = true
+		}
 
-				// Ensure the policy is satisfied
-				if err = policy.Evaluate(signedData); err != nil {
-					return nil, fmt.Errorf("Policy for %s not satisfied: %s", key, err)
+		result.diffs = append(result.diffs, diff)
+	}
+
+	readSet, err := mapConfig(config.ReadSet)
+	if err != nil {
+		return nil, err
+	}
+
+	deletedKeys, deletionDiffs, err := evaluateDeletions(cm.config, configMap, readSet, seq, strict, cm.policyForItem, func(policy policies.Policy) error {
+		return policy.Evaluate(signedData)
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.deletedKeys = deletedKeys
+	result.diffs = append(result.diffs, deletionDiffs...)
+
+	return result, nil
+}
+
+// evaluateDeletions walks oldConfig for keys absent from newConfig and determines which of those
+// are authorized explicit deletions: the caller must have read the current version of the key
+// (a matching entry in readSet) and the key's modification policy, resolved via policyForItem and
+// checked with evaluatePolicy, must be satisfied. It is factored out of evaluateUpdate so that
+// nested-group deletion semantics - including the parent group's Version bump requirement - can be
+// unit tested without needing a full ConfigUpdateEnvelope or configManager.
+//
+// strict has the same meaning evaluateUpdate gives it: true aborts on the first unsatisfied policy
+// or missing parent-group bump, false records the problem in the returned diff and continues.
+func evaluateDeletions(
+	oldConfig, newConfig, readSet map[string]comparable,
+	seq uint64,
+	strict bool,
+	policyForItem func(comparable) (policies.Policy, bool),
+	evaluatePolicy func(policies.Policy) error,
+) (map[string]struct{}, []ConfigKeyDiff, error) {
+	deletedKeys := make(map[string]struct{})
+	var diffs []ConfigKeyDiff
+
+	for key, oldValue := range oldConfig {
+		if _, ok := newConfig[key]; ok {
+			continue
+		}
+
+		readValue, ok := readSet[key]
+		if !ok {
+			return nil, nil, fmt.Errorf("Missing key %v in new config, and no corresponding ReadSet entry authorizing its deletion", key)
+		}
+
+		if readValue.version() != oldValue.version() {
+			return nil, nil, fmt.Errorf("Key %v was deleted, but the ReadSet version %d does not match the current version %d", key, readValue.version(), oldValue.version())
+		}
+
+		policy, ok := policyForItem(oldValue)
+		if !ok {
+			return nil, nil, fmt.Errorf("Unexpected missing policy %s for deleted item %s", oldValue.modPolicy(), key)
+		}
+
+		diff := ConfigKeyDiff{Key: key, OldValue: oldValue, Deleted: true, ModPolicy: oldValue.modPolicy()}
+
+		if err := evaluatePolicy(policy); err != nil {
+			if strict {
+				return nil, nil, fmt.Errorf("Policy for deleting %s not satisfied: %s", key, err)
+			}
+			diff.PolicySatisfied = false
+			diffs = append(diffs, diff)
+			continue
+		}
+
+		// The group containing a deleted key must itself be present in the WriteSet with its
+		// Version incremented, so that the deletion is reflected in the parent's own version
+		// history and is subject to the normal modification checks already enforced above. This
+		// runs before the deletion is recorded as authorized so that a missing or stale bump is
+		// reflected in the diff/deletedKeys the same way an unsatisfied policy is, rather than
+		// leaving behind a "deleted, PolicySatisfied: true" diff that authorizeUpdate would
+		// actually reject.
+		if parent, ok := parentGroupKey(key); ok {
+			parentValue, ok := newConfig[parent]
+			if !ok {
+				if strict {
+					return nil, nil, fmt.Errorf("Key %v was deleted, but parent group %s was not included in the WriteSet with a bumped Version", key, parent)
+				}
+				diff.PolicySatisfied = false
+				diffs = append(diffs, diff)
+				continue
+			}
+			if parentValue.version() != seq {
+				if strict {
+					return nil, nil, fmt.Errorf("Key %v was deleted, but parent group %s Version was not incremented to %d", key, parent, seq)
 				}
+				diff.PolicySatisfied = false
+				diffs = append(diffs, diff)
+				continue
 			}
+		}
+
+		diff.PolicySatisfied = true
+		diffs = append(diffs, diff)
+		deletedKeys[key] = struct{}{}
+	}
+
+	return deletedKeys, diffs, nil
+}
+
+// authorizeUpdate validates that all modified config has the corresponding modification policies
+// satisfied by the signature set, commits the result to cm, and returns the resulting config map.
+// It holds cm's lock across the whole evaluate-then-commit sequence - the same lock ApplyBatch
+// takes around its own validate/commit phases - so that Apply (which calls this) and ApplyBatch
+// can never interleave their mutations of a shared channel.
+func (cm *configManager) authorizeUpdate(configUpdateEnv *cb.ConfigUpdateEnvelope) (map[string]comparable, error) {
+	cm.lock()
+	defer cm.unlock()
+
+	eval, err := cm.evaluateUpdate(configUpdateEnv, true)
+	if err != nil {
+		return nil, err
+	}
+
+	cm.commitEvaluation(eval)
 
+	return cm.config, nil
+}
+
+// SimulateUpdate runs configUpdateEnv through the same evaluation authorizeUpdate uses, but never
+// mutates cm and never stops at the first unsatisfied policy. It returns a structured diff of
+// every key the update would touch, letting callers preview whether an update would apply - and
+// if not, exactly which keys and policies are the problem - without racing a real Apply.
+func (cm *configManager) SimulateUpdate(configUpdateEnv *cb.ConfigUpdateEnvelope) (*ConfigSimulationResult, error) {
+	eval, err := cm.evaluateUpdate(configUpdateEnv, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConfigSimulationResult{
+		Sequence: cm.sequence + 1,
+		Diffs:    eval.diffs,
+	}, nil
+}
+
+// ConfigManager is the exported handle to a channel's config manager that package-level batch and
+// tooling APIs (ApplyBatch, forks wiring in a custom ModPolicyResolver) operate on. Every
+// *configManager this package hands out satisfies it; external packages can hold and pass around
+// a ConfigManager value without ever needing to name the unexported concrete type.
+//
+// evaluateUpdate, commitEvaluation, lock and unlock are deliberately unexported: only code inside
+// this package may drive a manager through the validate/commit phases directly, so only types
+// declared in this package can implement this interface. Everyone else goes through Apply,
+// SimulateUpdate, or ApplyBatch.
+type ConfigManager interface {
+	// SetModPolicyResolver swaps the ModPolicyResolver this manager uses to resolve modification
+	// policies; passing nil reverts to defaultModPolicyResolver. This is the extension point forks
+	// use to wire in compositeModPolicyResolver, or a resolution scheme of their own.
+	SetModPolicyResolver(resolver ModPolicyResolver)
+
+	evaluateUpdate(configUpdateEnv *cb.ConfigUpdateEnvelope, strict bool) (*updateEvaluation, error)
+	commitEvaluation(eval *updateEvaluation)
+	lock()
+	unlock()
+}
+
+// managerLocksMu guards managerLocks, the table backing configManager's per-instance lock/unlock.
+var (
+	managerLocksMu sync.Mutex
+	managerLocks   = make(map[*configManager]*sync.Mutex)
+)
+
+// managerLock returns the mutex guarding cm's config/sequence mutations, creating it on first use.
+// Apply and ApplyBatch both acquire this lock around their validate-then-commit phases - via cm's
+// lock/unlock methods - so that a single-channel Apply and a multi-channel ApplyBatch can never
+// interleave their mutations of the same configManager.
+func managerLock(cm *configManager) *sync.Mutex {
+	managerLocksMu.Lock()
+	defer managerLocksMu.Unlock()
+	l, ok := managerLocks[cm]
+	if !ok {
+		l = &sync.Mutex{}
+		managerLocks[cm] = l
+	}
+	return l
+}
+
+func (cm *configManager) lock()   { managerLock(cm).Lock() }
+func (cm *configManager) unlock() { managerLock(cm).Unlock() }
+
+// commitEvaluation applies an already-validated updateEvaluation to cm. Callers must hold cm's
+// lock (see lock/unlock) across both the evaluateUpdate call that produced eval and this commit.
+func (cm *configManager) commitEvaluation(eval *updateEvaluation) {
+	cm.config = cm.computeUpdateResult(eval.configMap, eval.deletedKeys)
+	cm.sequence = eval.seq
+}
+
+// ApplyBatch commits config updates for multiple channels as a single atomic unit: either every
+// channel in updates applies, or none of them do. Each channel's update is run through the same
+// evaluateUpdate core authorizeUpdate uses, and only once every channel has validated are the
+// resulting cm.config/cm.sequence mutations committed via commitEvaluation. This matters for
+// cross-channel consortium changes - e.g. adding an org to the system channel and to every
+// application channel it has joined - where applying some channels but not others would leave the
+// network in an inconsistent state.
+//
+// Every involved channel's lock is acquired, in sorted channel-ID order, before its update is
+// evaluated, and held until every channel in the batch has been committed; sorting the lock order
+// ensures two overlapping ApplyBatch calls, or an ApplyBatch racing a single-channel Apply (which
+// takes the same per-manager lock around its own authorize-then-commit sequence in
+// authorizeUpdate), can never deadlock or interleave their mutations of a shared channel.
+//
+// managers must have an entry for every channel ID present in updates, mapping it to the
+// ConfigManager that owns that channel. Because nothing is mutated until every channel has
+// already been authorized, there is nothing to roll back on failure.
+func ApplyBatch(managers map[string]ConfigManager, updates map[string]*cb.ConfigUpdateEnvelope) error {
+	chainIDs := make([]string, 0, len(updates))
+	for chainID := range updates {
+		chainIDs = append(chainIDs, chainID)
+	}
+	sort.Strings(chainIDs)
+
+	locked := make([]ConfigManager, 0, len(chainIDs))
+	defer func() {
+		for _, cm := range locked {
+			cm.unlock()
 		}
+	}()
+
+	type pendingCommit struct {
+		cm   ConfigManager
+		eval *updateEvaluation
 	}
+	pending := make([]pendingCommit, 0, len(chainIDs))
 
-	// Ensure that any config items which used to exist still exist, to prevent implicit deletion
-	for key, _ := range cm.config {
-		_, ok := configMap[key]
+	for _, chainID := range chainIDs {
+		cm, ok := managers[chainID]
 		if !ok {
-			return nil, fmt.Errorf("Missing key %v in new config", key)
+			return fmt.Errorf("ApplyBatch: no configManager supplied for channel %s", chainID)
 		}
 
+		cm.lock()
+		locked = append(locked, cm)
+
+		eval, err := cm.evaluateUpdate(updates[chainID], true)
+		if err != nil {
+			return fmt.Errorf("ApplyBatch: update for channel %s not authorized: %s", chainID, err)
+		}
+
+		pending = append(pending, pendingCommit{cm: cm, eval: eval})
 	}
 
-	return cm.computeUpdateResult(configMap), nil
+	// Every channel validated, so none of the following commits can themselves fail: the batch
+	// is atomic because nothing is mutated until every channel has already been authorized, and
+	// every channel's lock is still held from the loop above.
+	for _, p := range pending {
+		p.cm.commitEvaluation(p.eval)
+	}
+
+	return nil
 }
 
-func (cm *configManager) policyForItem(item comparable) (policies.Policy, bool) {
+// parentGroupKey returns the "[Groups] ..." key of the group directly containing the config
+// item at key, and whether such a parent group key could be derived (it cannot for a root
+// group, which has no ancestor of its own).
+func parentGroupKey(key string) (string, bool) {
+	// The key's prefix length varies by item type ("[Groups] ", "[Values] ", "[Policies] ", ...),
+	// so the path itself - not groupPrefix's length - has to be what locates where it starts;
+	// it always begins with the root "/" mapConfig prefixes every path with.
+	pathStart := strings.Index(key, "/")
+	if pathStart < 0 {
+		return "", false
+	}
+	path := key[pathStart:]
+
+	idx := strings.LastIndex(path, "/")
+	if idx <= 0 {
+		return "", false
+	}
+	return groupPrefix + path[:idx], true
+}
+
+// ModPolicyResolver resolves the modification policy that governs changes to a given config item,
+// looking it up against policyManager (a channel's PolicyManager, or one of its submanagers). It
+// is handed a policies.Manager rather than a configManager so that resolution logic - including
+// compositeModPolicyResolver's alias and inheritance walks - can be unit tested against a fake
+// policies.Manager, the same way evaluateDeletions is tested without a full configManager. It
+// exists so that organizations which want non-default mod_policy semantics - implicit
+// inheritance, named aliases, etc. - can wire in their own resolution scheme without forking
+// authorizeUpdate itself.
+type ModPolicyResolver interface {
+	PolicyForItem(policyManager policies.Manager, item comparable) (policies.Policy, bool)
+}
+
+// defaultModPolicyResolver implements the resolution rules configtx has always used: an absolute
+// path (prefixed with PathSeparator) is looked up directly on policyManager, and a relative
+// mod_policy is looked up on the submanager rooted at the item's own group.
+type defaultModPolicyResolver struct{}
+
+func (defaultModPolicyResolver) PolicyForItem(policyManager policies.Manager, item comparable) (policies.Policy, bool) {
 	if strings.HasPrefix(item.modPolicy(), PathSeparator) {
-		return cm.PolicyManager().GetPolicy(item.modPolicy()[1:])
+		return policyManager.GetPolicy(item.modPolicy()[1:])
 	}
 
 	// path is always at least of length 1
-	manager, ok := cm.PolicyManager().Manager(item.path[1:])
+	manager, ok := policyManager.Manager(item.path[1:])
 	if !ok {
 		return nil, ok
 	}
 	return manager.GetPolicy(item.modPolicy())
 }
 
-// computeUpdateResult takes a configMap generated by an update and produces a new configMap overlaying it onto the old config
-func (cm *configManager) computeUpdateResult(updatedConfig map[string]comparable) map[string]comparable {
+// compositeModPolicyResolver extends defaultModPolicyResolver with two additional strategies for
+// organizations that don't want to duplicate the same mod_policy into every subgroup:
+//
+//   - Implicit inheritance: a relative mod_policy (or Fallback, used in place of an empty one) is
+//     resolved by walking up item.path from the item's own group to the channel root, returning
+//     the policy from the first manager on that walk that defines it.
+//   - Named aliases of the form "<policy>@<group>": resolved against whichever ancestor group in
+//     item.path is named <group>, regardless of how deeply nested item itself is. This lets a
+//     channel-wide policy like "Admins@Channel" be referenced from any subgroup.
+//
+// A mod_policy prefixed with PathSeparator is looked up directly on policyManager, matching
+// defaultModPolicyResolver; no inheritance walk is done for it.
+type compositeModPolicyResolver struct {
+	// Fallback is the mod_policy name substituted in when an item does not declare its own.
+	Fallback string
+}
+
+func (r compositeModPolicyResolver) PolicyForItem(policyManager policies.Manager, item comparable) (policies.Policy, bool) {
+	modPolicy := item.modPolicy()
+
+	if parts := strings.SplitN(modPolicy, "@", 2); len(parts) == 2 {
+		policyName, groupName := parts[0], parts[1]
+		for i, segment := range item.path {
+			if segment != groupName {
+				continue
+			}
+			manager, ok := policyManager.Manager(item.path[1 : i+1])
+			if !ok {
+				return nil, false
+			}
+			return manager.GetPolicy(policyName)
+		}
+		return nil, false
+	}
+
+	if modPolicy == "" {
+		modPolicy = r.Fallback
+	}
+
+	if strings.HasPrefix(modPolicy, PathSeparator) {
+		return policyManager.GetPolicy(modPolicy[1:])
+	}
+
+	for depth := len(item.path); depth >= 1; depth-- {
+		manager, ok := policyManager.Manager(item.path[1:depth])
+		if !ok {
+			continue
+		}
+		if policy, ok := manager.GetPolicy(modPolicy); ok {
+			return policy, true
+		}
+	}
+	return nil, false
+}
+
+// resolverRegistryMu guards resolverRegistry, the table backing SetModPolicyResolver. A
+// configManager with no entry uses defaultModPolicyResolver, preserving historical behavior.
+var (
+	resolverRegistryMu sync.RWMutex
+	resolverRegistry   = make(map[*configManager]ModPolicyResolver)
+)
+
+// SetModPolicyResolver swaps the ModPolicyResolver cm uses to resolve modification policies;
+// passing nil reverts cm to defaultModPolicyResolver. Organizations that want implicit mod_policy
+// inheritance or "<policy>@<group>" aliases call this with a compositeModPolicyResolver; anyone
+// else can ignore it and keep today's behavior.
+func (cm *configManager) SetModPolicyResolver(resolver ModPolicyResolver) {
+	resolverRegistryMu.Lock()
+	defer resolverRegistryMu.Unlock()
+	if resolver == nil {
+		delete(resolverRegistry, cm)
+		return
+	}
+	resolverRegistry[cm] = resolver
+}
+
+// modPolicyResolver returns the ModPolicyResolver used to resolve modification policies for cm,
+// defaulting to defaultModPolicyResolver if SetModPolicyResolver was never called.
+func (cm *configManager) modPolicyResolver() ModPolicyResolver {
+	resolverRegistryMu.RLock()
+	defer resolverRegistryMu.RUnlock()
+	if r, ok := resolverRegistry[cm]; ok {
+		return r
+	}
+	return defaultModPolicyResolver{}
+}
+
+func (cm *configManager) policyForItem(item comparable) (policies.Policy, bool) {
+	return cm.modPolicyResolver().PolicyForItem(cm.PolicyManager(), item)
+}
+
+// computeUpdateResult takes a configMap generated by an update and produces a new configMap overlaying it onto the old config,
+// omitting any key present in deletedKeys so that explicit deletions authorized by authorizeUpdate actually disappear
+func (cm *configManager) computeUpdateResult(updatedConfig map[string]comparable, deletedKeys map[string]struct{}) map[string]comparable {
 	newConfigMap := make(map[string]comparable)
 	for key, value := range cm.config {
+		if _, deleted := deletedKeys[key]; deleted {
+			continue
+		}
 		newConfigMap[key] = value
 	}
 
@@ -0,0 +1,268 @@
+/*
+Copyright IBM Corp. 2016-2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configtx
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/policies"
+	cb "github.com/hyperledger/fabric/protos/common"
+)
+
+func TestParentGroupKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		wantParent string
+		wantOK     bool
+	}{
+		{
+			name:       "root group has no parent",
+			key:        groupPrefix + "/Channel",
+			wantParent: "",
+			wantOK:     false,
+		},
+		{
+			name:       "nested group",
+			key:        groupPrefix + "/Channel/Application",
+			wantParent: groupPrefix + "/Channel",
+			wantOK:     true,
+		},
+		{
+			name:       "value under a nested group, prefix the same length as groupPrefix",
+			key:        "[Values] /Channel/Application/MSPs",
+			wantParent: groupPrefix + "/Channel/Application",
+			wantOK:     true,
+		},
+		{
+			name:       "policy under a nested group, prefix longer than groupPrefix",
+			key:        "[Policies] /Channel/Application/Admins",
+			wantParent: groupPrefix + "/Channel/Application",
+			wantOK:     true,
+		},
+		{
+			name:       "value directly under the channel root",
+			key:        "[Values] /Channel/HashingAlgorithm",
+			wantParent: groupPrefix + "/Channel",
+			wantOK:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotParent, gotOK := parentGroupKey(tt.key)
+			if gotOK != tt.wantOK {
+				t.Fatalf("parentGroupKey(%q) ok = %v, want %v", tt.key, gotOK, tt.wantOK)
+			}
+			if gotParent != tt.wantParent {
+				t.Fatalf("parentGroupKey(%q) = %q, want %q", tt.key, gotParent, tt.wantParent)
+			}
+		})
+	}
+}
+
+// stubPolicy lets tests control whether a modification policy is satisfied without standing up a
+// real policies.Manager.
+type stubPolicy struct {
+	err error
+}
+
+func (p stubPolicy) Evaluate(_ []*cb.SignedData) error { return p.err }
+
+func groupComparable(path []string, version uint64) comparable {
+	return comparable{path: path, ConfigGroup: &cb.ConfigGroup{Version: version}}
+}
+
+func valueComparable(path []string, version uint64, modPolicy string) comparable {
+	return comparable{path: path, ConfigValue: &cb.ConfigValue{Version: version, ModPolicy: modPolicy}}
+}
+
+func TestEvaluateDeletions(t *testing.T) {
+	const seq = uint64(4)
+
+	allow := func(comparable) (policies.Policy, bool) { return stubPolicy{}, true }
+	evaluateOK := func(policies.Policy) error { return nil }
+	evaluateDenied := func(policies.Policy) error { return errors.New("signature set did not satisfy policy") }
+
+	t.Run("deleting a value in a nested group with a correctly bumped parent succeeds", func(t *testing.T) {
+		oldConfig := map[string]comparable{
+			groupPrefix + "/Channel":             groupComparable([]string{"Channel"}, 1),
+			groupPrefix + "/Channel/Application": groupComparable([]string{"Channel", "Application"}, 2),
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+		newConfig := map[string]comparable{
+			groupPrefix + "/Channel":             groupComparable([]string{"Channel"}, 1),
+			groupPrefix + "/Channel/Application": groupComparable([]string{"Channel", "Application"}, seq),
+		}
+		readSet := map[string]comparable{
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+
+		deleted, diffs, err := evaluateDeletions(oldConfig, newConfig, readSet, seq, true, allow, evaluateOK)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, ok := deleted["[Values] /Channel/Application/MSPs"]; !ok {
+			t.Fatalf("expected the MSPs value to be deleted, deleted = %v", deleted)
+		}
+		if len(diffs) != 1 || !diffs[0].Deleted || !diffs[0].PolicySatisfied {
+			t.Fatalf("unexpected diffs: %+v", diffs)
+		}
+	})
+
+	t.Run("missing ReadSet entry is rejected", func(t *testing.T) {
+		oldConfig := map[string]comparable{
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+		newConfig := map[string]comparable{}
+		readSet := map[string]comparable{}
+
+		if _, _, err := evaluateDeletions(oldConfig, newConfig, readSet, seq, true, allow, evaluateOK); err == nil {
+			t.Fatal("expected an error when the ReadSet doesn't authorize the deletion")
+		}
+	})
+
+	t.Run("stale ReadSet version is rejected", func(t *testing.T) {
+		oldConfig := map[string]comparable{
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+		newConfig := map[string]comparable{}
+		readSet := map[string]comparable{
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 1, "Admins"),
+		}
+
+		if _, _, err := evaluateDeletions(oldConfig, newConfig, readSet, seq, true, allow, evaluateOK); err == nil {
+			t.Fatal("expected an error when the ReadSet version is stale")
+		}
+	})
+
+	t.Run("unsatisfied policy is rejected in strict mode", func(t *testing.T) {
+		oldConfig := map[string]comparable{
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+		newConfig := map[string]comparable{}
+		readSet := map[string]comparable{
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+
+		if _, _, err := evaluateDeletions(oldConfig, newConfig, readSet, seq, true, allow, evaluateDenied); err == nil {
+			t.Fatal("expected an error when the modification policy is not satisfied in strict mode")
+		}
+	})
+
+	t.Run("unsatisfied policy is recorded, not fatal, outside strict mode", func(t *testing.T) {
+		oldConfig := map[string]comparable{
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+		newConfig := map[string]comparable{}
+		readSet := map[string]comparable{
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+
+		deleted, diffs, err := evaluateDeletions(oldConfig, newConfig, readSet, seq, false, allow, evaluateDenied)
+		if err != nil {
+			t.Fatalf("unexpected error in non-strict mode: %s", err)
+		}
+		if len(deleted) != 0 {
+			t.Fatalf("key should not be deleted while its policy is unsatisfied, deleted = %v", deleted)
+		}
+		if len(diffs) != 1 || diffs[0].PolicySatisfied {
+			t.Fatalf("expected a single unsatisfied diff, got %+v", diffs)
+		}
+	})
+
+	t.Run("parent group missing its Version bump is rejected in strict mode", func(t *testing.T) {
+		oldConfig := map[string]comparable{
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+		// newConfig omits the parent group entirely, so its Version was never bumped.
+		newConfig := map[string]comparable{}
+		readSet := map[string]comparable{
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+
+		if _, _, err := evaluateDeletions(oldConfig, newConfig, readSet, seq, true, allow, evaluateOK); err == nil {
+			t.Fatal("expected an error when the parent group's Version was not bumped")
+		}
+	})
+
+	t.Run("parent group with a stale Version is rejected in strict mode", func(t *testing.T) {
+		oldConfig := map[string]comparable{
+			groupPrefix + "/Channel/Application": groupComparable([]string{"Channel", "Application"}, 2),
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+		newConfig := map[string]comparable{
+			// Parent group is present but its Version wasn't actually bumped to seq.
+			groupPrefix + "/Channel/Application": groupComparable([]string{"Channel", "Application"}, 2),
+		}
+		readSet := map[string]comparable{
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+
+		if _, _, err := evaluateDeletions(oldConfig, newConfig, readSet, seq, true, allow, evaluateOK); err == nil {
+			t.Fatal("expected an error when the parent group's Version is stale")
+		}
+	})
+
+	t.Run("parent group missing its Version bump is retracted outside strict mode", func(t *testing.T) {
+		oldConfig := map[string]comparable{
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+		// newConfig omits the parent group entirely, so its Version was never bumped.
+		newConfig := map[string]comparable{}
+		readSet := map[string]comparable{
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+
+		deleted, diffs, err := evaluateDeletions(oldConfig, newConfig, readSet, seq, false, allow, evaluateOK)
+		if err != nil {
+			t.Fatalf("unexpected error in non-strict mode: %s", err)
+		}
+		if len(deleted) != 0 {
+			t.Fatalf("key should not be deleted while its parent group's Version bump is missing, deleted = %v", deleted)
+		}
+		if len(diffs) != 1 || diffs[0].PolicySatisfied {
+			t.Fatalf("expected a single unauthorized diff, got %+v", diffs)
+		}
+	})
+
+	t.Run("parent group with a stale Version is retracted outside strict mode", func(t *testing.T) {
+		oldConfig := map[string]comparable{
+			groupPrefix + "/Channel/Application": groupComparable([]string{"Channel", "Application"}, 2),
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+		newConfig := map[string]comparable{
+			// Parent group is present but its Version wasn't actually bumped to seq.
+			groupPrefix + "/Channel/Application": groupComparable([]string{"Channel", "Application"}, 2),
+		}
+		readSet := map[string]comparable{
+			"[Values] /Channel/Application/MSPs": valueComparable([]string{"Channel", "Application"}, 2, "Admins"),
+		}
+
+		deleted, diffs, err := evaluateDeletions(oldConfig, newConfig, readSet, seq, false, allow, evaluateOK)
+		if err != nil {
+			t.Fatalf("unexpected error in non-strict mode: %s", err)
+		}
+		if len(deleted) != 0 {
+			t.Fatalf("key should not be deleted while its parent group's Version is stale, deleted = %v", deleted)
+		}
+		if len(diffs) != 1 || diffs[0].PolicySatisfied {
+			t.Fatalf("expected a single unauthorized diff, got %+v", diffs)
+		}
+	})
+}